@@ -0,0 +1,138 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// TokenSource supplies bearer tokens for outgoing requests, refreshing
+// them as needed.
+type TokenSource interface {
+	Token(ctx context.Context) (string, error)
+}
+
+// ClientCredentialsSource obtains bearer tokens from an OIDC provider's
+// token endpoint using the client_credentials grant, caching each token
+// until shortly before it expires.
+type ClientCredentialsSource struct {
+	tokenEndpoint string
+	clientID      string
+	clientSecret  string
+	audience      string
+
+	mu        sync.Mutex
+	token     string
+	expiresAt time.Time
+}
+
+// NewClientCredentialsSource discovers config.Issuer's token endpoint and
+// returns a TokenSource that authenticates as config.ClientID via the
+// client_credentials grant.
+func NewClientCredentialsSource(ctx context.Context, config Config) (*ClientCredentialsSource, error) {
+	meta, err := discoverProvider(ctx, http.DefaultClient, config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovering OIDC provider %q: %v", config.Issuer, err)
+	}
+	return &ClientCredentialsSource{
+		tokenEndpoint: meta.TokenEndpoint,
+		clientID:      config.ClientID,
+		clientSecret:  config.ClientSecret,
+		audience:      config.Audience,
+	}, nil
+}
+
+// Token implements TokenSource, requesting a new token only once the
+// cached one is within a minute of expiring.
+func (s *ClientCredentialsSource) Token(ctx context.Context) (string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.token != "" && time.Now().Add(time.Minute).Before(s.expiresAt) {
+		return s.token, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {s.clientID},
+		"client_secret": {s.clientSecret},
+	}
+	if s.audience != "" {
+		form.Set("audience", s.audience)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, s.tokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("requesting token from %s: %s", s.tokenEndpoint, resp.Status)
+	}
+
+	var body struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		return "", err
+	}
+
+	s.token = body.AccessToken
+	s.expiresAt = time.Now().Add(time.Duration(body.ExpiresIn) * time.Second)
+	return s.token, nil
+}
+
+// Transport wraps a base http.RoundTripper, attaching an
+// "Authorization: Bearer <jwt>" header obtained from Source to every
+// request.
+type Transport struct {
+	Base   http.RoundTripper
+	Source TokenSource
+}
+
+// NewTransport returns a Transport that authenticates requests with
+// tokens from source, wrapping base (or http.DefaultTransport, if base is
+// nil).
+func NewTransport(base http.RoundTripper, source TokenSource) *Transport {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &Transport{Base: base, Source: source}
+}
+
+// RoundTrip implements http.RoundTripper.
+func (t *Transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token, err := t.Source.Token(req.Context())
+	if err != nil {
+		return nil, fmt.Errorf("obtaining bearer token: %v", err)
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return t.Base.RoundTrip(req)
+}
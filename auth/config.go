@@ -0,0 +1,46 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package auth provides OIDC bearer-token authentication for Pilosa: a
+// client_credentials TokenSource and RoundTripper for outgoing requests,
+// and a JWKS-backed Validator and HTTP middleware for verifying incoming
+// requests.
+package auth
+
+import "time"
+
+// Config holds the settings needed to obtain and validate OIDC bearer
+// tokens against a single issuer.
+type Config struct {
+	// Issuer is the OIDC issuer URL, e.g. "https://accounts.example.com".
+	// Its ".well-known/openid-configuration" document is used to
+	// discover the token and JWKS endpoints.
+	Issuer string
+
+	// ClientID and ClientSecret authenticate this node or CLI command to
+	// the issuer when obtaining tokens via the client_credentials grant.
+	ClientID     string
+	ClientSecret string
+
+	// Audience is required to appear in the "aud" claim of tokens this
+	// node accepts; NewValidator returns an error if it is empty. If set,
+	// it is also requested as the "audience" parameter when obtaining
+	// tokens.
+	Audience string
+
+	// JWKSRefreshInterval is how often a Validator re-fetches the
+	// issuer's JWKS on a timer, independent of the on-demand refresh
+	// that happens whenever an unrecognized key ID is seen.
+	JWKSRefreshInterval time.Duration
+}
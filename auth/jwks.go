@@ -0,0 +1,203 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// jwk is a single entry of a JSON Web Key Set, as returned by an OIDC
+// provider's jwks_uri. Only the fields needed to build an RSA public key
+// are kept.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+}
+
+type jwksDocument struct {
+	Keys []jwk `json:"keys"`
+}
+
+// minOnDemandRefreshInterval bounds how often an unknown-kid lookup can
+// trigger a JWKS fetch. Without it, a client sending tokens with random
+// "kid" values could force one outbound JWKS request per incoming
+// request, amplifying load against both this node and the OIDC provider.
+const minOnDemandRefreshInterval = 30 * time.Second
+
+// jwksCache fetches and caches an OIDC provider's JSON Web Key Set, keyed
+// by "kid". It refreshes on a timer and also on demand, whenever a
+// signing key ID is requested that isn't in the current cache, so that a
+// provider's key rotation doesn't require restarting Pilosa. On-demand
+// refreshes are coalesced and rate-limited by minOnDemandRefreshInterval.
+type jwksCache struct {
+	client  *http.Client
+	jwksURI string
+
+	mu          sync.RWMutex
+	keys        map[string]*rsa.PublicKey
+	lastRefresh time.Time
+	inflight    chan struct{}
+}
+
+func newJWKSCache(client *http.Client, jwksURI string, refreshInterval time.Duration) (*jwksCache, error) {
+	c := &jwksCache{
+		client:  client,
+		jwksURI: jwksURI,
+		keys:    map[string]*rsa.PublicKey{},
+	}
+	if err := c.refresh(context.Background()); err != nil {
+		return nil, err
+	}
+	if refreshInterval > 0 {
+		go c.refreshLoop(refreshInterval)
+	}
+	return c, nil
+}
+
+func (c *jwksCache) refreshLoop(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		_ = c.refresh(context.Background())
+	}
+}
+
+func (c *jwksCache) refresh(ctx context.Context) error {
+	req, err := http.NewRequest(http.MethodGet, c.jwksURI, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.client.Do(req.WithContext(ctx))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("fetching %s: %s", c.jwksURI, resp.Status)
+	}
+
+	var doc jwksDocument
+	if err := json.NewDecoder(resp.Body).Decode(&doc); err != nil {
+		return fmt.Errorf("decoding %s: %v", c.jwksURI, err)
+	}
+
+	keys := make(map[string]*rsa.PublicKey, len(doc.Keys))
+	for _, k := range doc.Keys {
+		if k.Kty != "RSA" {
+			continue
+		}
+		pub, err := k.rsaPublicKey()
+		if err != nil {
+			// A single malformed or not-yet-fully-rotated-in key
+			// shouldn't take down validation for every other valid key
+			// in the set.
+			continue
+		}
+		keys[k.Kid] = pub
+	}
+
+	c.mu.Lock()
+	c.keys = keys
+	c.lastRefresh = time.Now()
+	c.mu.Unlock()
+	return nil
+}
+
+func (k jwk) rsaPublicKey() (*rsa.PublicKey, error) {
+	nBytes, err := base64.RawURLEncoding.DecodeString(k.N)
+	if err != nil {
+		return nil, err
+	}
+	eBytes, err := base64.RawURLEncoding.DecodeString(k.E)
+	if err != nil {
+		return nil, err
+	}
+	return &rsa.PublicKey{
+		N: new(big.Int).SetBytes(nBytes),
+		E: int(new(big.Int).SetBytes(eBytes).Int64()),
+	}, nil
+}
+
+// Key returns the cached public key for kid, re-fetching the JWKS at most
+// once if kid isn't already cached; the fetch is skipped if the JWKS was
+// fetched within minOnDemandRefreshInterval (see refreshForUnknownKey).
+func (c *jwksCache) Key(ctx context.Context, kid string) (*rsa.PublicKey, error) {
+	c.mu.RLock()
+	key, ok := c.keys[kid]
+	c.mu.RUnlock()
+	if ok {
+		return key, nil
+	}
+
+	if err := c.refreshForUnknownKey(ctx); err != nil {
+		return nil, err
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	key, ok = c.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// refreshForUnknownKey refreshes the JWKS on behalf of a Key lookup that
+// missed the cache. Concurrent callers are coalesced onto a single
+// in-flight request (singleflight), and refreshes are skipped entirely if
+// one already happened within minOnDemandRefreshInterval, so that a flood
+// of tokens with unknown "kid" values can trigger at most one outbound
+// JWKS fetch per interval.
+func (c *jwksCache) refreshForUnknownKey(ctx context.Context) error {
+	c.mu.Lock()
+	if time.Since(c.lastRefresh) < minOnDemandRefreshInterval {
+		c.mu.Unlock()
+		return nil
+	}
+	if c.inflight != nil {
+		wait := c.inflight
+		c.mu.Unlock()
+		<-wait
+		return nil
+	}
+	done := make(chan struct{})
+	c.inflight = done
+	c.mu.Unlock()
+
+	err := c.refresh(ctx)
+
+	c.mu.Lock()
+	if err != nil {
+		// A failed fetch counts against the interval too, so an
+		// unreachable provider is not retried on every unknown kid.
+		c.lastRefresh = time.Now()
+	}
+	c.inflight = nil
+	c.mu.Unlock()
+	close(done)
+
+	return err
+}
@@ -0,0 +1,303 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func testSigningKey(t *testing.T) *rsa.PrivateKey {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating test key: %v", err)
+	}
+	return key
+}
+
+// signTestToken assembles a JWT from raw header and claims maps, so tests
+// can produce malformed or edge-case claim shapes (e.g. a string "aud"
+// instead of an array) that the Claims struct's own JSON marshaling would
+// never generate. If key is nil, the signature segment is left empty.
+func signTestToken(t *testing.T, key *rsa.PrivateKey, header, claims map[string]interface{}) string {
+	t.Helper()
+
+	headerJSON, err := json.Marshal(header)
+	if err != nil {
+		t.Fatalf("marshaling header: %v", err)
+	}
+	claimsJSON, err := json.Marshal(claims)
+	if err != nil {
+		t.Fatalf("marshaling claims: %v", err)
+	}
+
+	signingInput := base64.RawURLEncoding.EncodeToString(headerJSON) + "." + base64.RawURLEncoding.EncodeToString(claimsJSON)
+
+	if key == nil {
+		return signingInput + "."
+	}
+
+	digest := sha256.Sum256([]byte(signingInput))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatalf("signing test token: %v", err)
+	}
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func TestVerifyJWT(t *testing.T) {
+	key := testSigningKey(t)
+	otherKey := testSigningKey(t)
+
+	const issuer = "https://issuer.example.com/"
+	const audienceValue = "pilosa-cluster"
+
+	validClaims := func() map[string]interface{} {
+		return map[string]interface{}{
+			"sub": "user-1",
+			"iss": issuer,
+			"aud": audienceValue,
+			"exp": time.Now().Add(time.Hour).Unix(),
+		}
+	}
+
+	keyFunc := func(kid string) (*rsa.PublicKey, error) {
+		return &key.PublicKey, nil
+	}
+
+	tests := []struct {
+		name          string
+		token         func() string
+		keyFunc       func(kid string) (*rsa.PublicKey, error)
+		issuer        string
+		audienceValue string
+		wantErr       bool
+	}{
+		{
+			name: "valid token",
+			token: func() string {
+				return signTestToken(t, key, map[string]interface{}{"alg": "RS256", "kid": "k1"}, validClaims())
+			},
+			keyFunc:       keyFunc,
+			issuer:        issuer,
+			audienceValue: audienceValue,
+		},
+		{
+			name: "valid token with array audience",
+			token: func() string {
+				claims := validClaims()
+				claims["aud"] = []string{"other-service", audienceValue}
+				return signTestToken(t, key, map[string]interface{}{"alg": "RS256", "kid": "k1"}, claims)
+			},
+			keyFunc:       keyFunc,
+			issuer:        issuer,
+			audienceValue: audienceValue,
+		},
+		{
+			name: "alg none is rejected",
+			token: func() string {
+				return signTestToken(t, nil, map[string]interface{}{"alg": "none", "kid": "k1"}, validClaims())
+			},
+			keyFunc:       keyFunc,
+			issuer:        issuer,
+			audienceValue: audienceValue,
+			wantErr:       true,
+		},
+		{
+			name: "alg HS256 is rejected",
+			token: func() string {
+				return signTestToken(t, key, map[string]interface{}{"alg": "HS256", "kid": "k1"}, validClaims())
+			},
+			keyFunc:       keyFunc,
+			issuer:        issuer,
+			audienceValue: audienceValue,
+			wantErr:       true,
+		},
+		{
+			name: "expired token",
+			token: func() string {
+				claims := validClaims()
+				claims["exp"] = time.Now().Add(-time.Hour).Unix()
+				return signTestToken(t, key, map[string]interface{}{"alg": "RS256", "kid": "k1"}, claims)
+			},
+			keyFunc:       keyFunc,
+			issuer:        issuer,
+			audienceValue: audienceValue,
+			wantErr:       true,
+		},
+		{
+			name: "wrong issuer",
+			token: func() string {
+				claims := validClaims()
+				claims["iss"] = "https://someone-else.example.com/"
+				return signTestToken(t, key, map[string]interface{}{"alg": "RS256", "kid": "k1"}, claims)
+			},
+			keyFunc:       keyFunc,
+			issuer:        issuer,
+			audienceValue: audienceValue,
+			wantErr:       true,
+		},
+		{
+			name: "wrong audience",
+			token: func() string {
+				claims := validClaims()
+				claims["aud"] = "some-other-service"
+				return signTestToken(t, key, map[string]interface{}{"alg": "RS256", "kid": "k1"}, claims)
+			},
+			keyFunc:       keyFunc,
+			issuer:        issuer,
+			audienceValue: audienceValue,
+			wantErr:       true,
+		},
+		{
+			name: "tampered signature",
+			token: func() string {
+				token := signTestToken(t, key, map[string]interface{}{"alg": "RS256", "kid": "k1"}, validClaims())
+				parts := splitToken(token)
+				// Change the first signature character, which carries six
+				// full bits of the signature; the last character also carries
+				// unused padding bits, so changing it may not change the
+				// decoded signature at all.
+				sig := []byte(parts[2])
+				if sig[0] == 'A' {
+					sig[0] = 'B'
+				} else {
+					sig[0] = 'A'
+				}
+				return parts[0] + "." + parts[1] + "." + string(sig)
+			},
+			keyFunc:       keyFunc,
+			issuer:        issuer,
+			audienceValue: audienceValue,
+			wantErr:       true,
+		},
+		{
+			name: "signed with the wrong key",
+			token: func() string {
+				return signTestToken(t, otherKey, map[string]interface{}{"alg": "RS256", "kid": "k1"}, validClaims())
+			},
+			keyFunc:       keyFunc,
+			issuer:        issuer,
+			audienceValue: audienceValue,
+			wantErr:       true,
+		},
+		{
+			name: "unknown kid",
+			token: func() string {
+				return signTestToken(t, key, map[string]interface{}{"alg": "RS256", "kid": "missing"}, validClaims())
+			},
+			keyFunc: func(kid string) (*rsa.PublicKey, error) {
+				return nil, fmt.Errorf("no key for kid %q", kid)
+			},
+			issuer:        issuer,
+			audienceValue: audienceValue,
+			wantErr:       true,
+		},
+		{
+			name: "malformed token, missing segments",
+			token: func() string {
+				return "not-a-jwt"
+			},
+			keyFunc:       keyFunc,
+			issuer:        issuer,
+			audienceValue: audienceValue,
+			wantErr:       true,
+		},
+		{
+			name: "malformed base64url header",
+			token: func() string {
+				token := signTestToken(t, key, map[string]interface{}{"alg": "RS256", "kid": "k1"}, validClaims())
+				parts := splitToken(token)
+				return "not!base64url" + "." + parts[1] + "." + parts[2]
+			},
+			keyFunc:       keyFunc,
+			issuer:        issuer,
+			audienceValue: audienceValue,
+			wantErr:       true,
+		},
+		{
+			name: "malformed JSON in claims",
+			token: func() string {
+				header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"k1"}`))
+				claims := base64.RawURLEncoding.EncodeToString([]byte(`not json`))
+				signingInput := header + "." + claims
+				digest := sha256.Sum256([]byte(signingInput))
+				sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+				if err != nil {
+					t.Fatalf("signing: %v", err)
+				}
+				return signingInput + "." + base64.RawURLEncoding.EncodeToString(sig)
+			},
+			keyFunc:       keyFunc,
+			issuer:        issuer,
+			audienceValue: audienceValue,
+			wantErr:       true,
+		},
+		{
+			name: "empty audienceValue skips the aud check",
+			token: func() string {
+				claims := validClaims()
+				claims["aud"] = "some-other-service"
+				return signTestToken(t, key, map[string]interface{}{"alg": "RS256", "kid": "k1"}, claims)
+			},
+			keyFunc:       keyFunc,
+			issuer:        issuer,
+			audienceValue: "",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims, err := verifyJWT(tt.token(), tt.keyFunc, tt.issuer, tt.audienceValue)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got claims %+v", claims)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if claims.Subject != "user-1" {
+				t.Errorf("Subject = %q, want %q", claims.Subject, "user-1")
+			}
+		})
+	}
+}
+
+// splitToken splits a dot-separated JWT into its three segments, panicking
+// if it isn't well-formed; only used to mutate an otherwise-valid test token.
+func splitToken(token string) [3]string {
+	var parts [3]string
+	start := 0
+	part := 0
+	for i := 0; i < len(token); i++ {
+		if token[i] == '.' {
+			parts[part] = token[start:i]
+			start = i + 1
+			part++
+		}
+	}
+	parts[part] = token[start:]
+	return parts
+}
@@ -0,0 +1,110 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package auth
+
+import (
+	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Principal identifies the authenticated caller of a request, derived
+// from a validated bearer token's claims, for use in index/frame-level
+// ACL checks.
+type Principal struct {
+	Subject string
+	Scope   string
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext returns the Principal attached to ctx by a
+// Validator's Middleware, if any.
+func PrincipalFromContext(ctx context.Context) (*Principal, bool) {
+	p, ok := ctx.Value(principalKey{}).(*Principal)
+	return p, ok
+}
+
+// Validator validates bearer tokens against a single OIDC issuer,
+// verifying their RS256 signature against the issuer's JWKS (discovered
+// via its ".well-known/openid-configuration" document) and checking
+// their iss/aud/exp claims.
+type Validator struct {
+	issuer   string
+	audience string
+	jwks     *jwksCache
+}
+
+// NewValidator discovers config.Issuer's JWKS endpoint and returns a
+// Validator for it, refreshing the JWKS every config.JWKSRefreshInterval
+// and on demand whenever an unrecognized key ID is seen.
+//
+// config.Audience is required: without an audience check, any token
+// minted by the issuer for a different service would also be accepted
+// here.
+func NewValidator(ctx context.Context, config Config) (*Validator, error) {
+	if config.Audience == "" {
+		return nil, errors.New("auth: Audience must be configured (--auth.oidc-audience); validating without one would accept tokens issued for any other service at the same issuer")
+	}
+
+	meta, err := discoverProvider(ctx, http.DefaultClient, config.Issuer)
+	if err != nil {
+		return nil, fmt.Errorf("discovering OIDC provider %q: %v", config.Issuer, err)
+	}
+
+	jwks, err := newJWKSCache(http.DefaultClient, meta.JWKSURI, config.JWKSRefreshInterval)
+	if err != nil {
+		return nil, fmt.Errorf("fetching JWKS for %q: %v", config.Issuer, err)
+	}
+
+	return &Validator{issuer: config.Issuer, audience: config.Audience, jwks: jwks}, nil
+}
+
+// Middleware wraps next, rejecting requests that lack a valid bearer
+// token and attaching the resulting Principal to the request context for
+// downstream index/frame-level ACL checks.
+func (v *Validator) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok {
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+
+		claims, err := verifyJWT(token, func(kid string) (*rsa.PublicKey, error) {
+			return v.jwks.Key(r.Context(), kid)
+		}, v.issuer, v.audience)
+		if err != nil {
+			http.Error(w, fmt.Sprintf("invalid bearer token: %v", err), http.StatusUnauthorized)
+			return
+		}
+
+		principal := &Principal{Subject: claims.Subject, Scope: claims.Scope}
+		r = r.WithContext(context.WithValue(r.Context(), principalKey{}, principal))
+		next.ServeHTTP(w, r)
+	})
+}
+
+func bearerToken(r *http.Request) (string, bool) {
+	const prefix = "Bearer "
+	h := r.Header.Get("Authorization")
+	if !strings.HasPrefix(h, prefix) {
+		return "", false
+	}
+	return strings.TrimPrefix(h, prefix), true
+}
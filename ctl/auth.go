@@ -0,0 +1,37 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"time"
+
+	"github.com/pilosa/pilosa/auth"
+	"github.com/spf13/pflag"
+)
+
+// CommandWithAuthSupport is implemented by commands that support OIDC
+// bearer-token authentication in addition to (or instead of) mTLS.
+type CommandWithAuthSupport interface {
+	AuthConfiguration() auth.Config
+}
+
+// SetAuthConfig creates common OIDC bearer-token auth flags.
+func SetAuthConfig(flags *pflag.FlagSet, issuer *string, clientID *string, clientSecret *string, audience *string, jwksRefreshInterval *time.Duration) {
+	flags.StringVarP(issuer, "auth.oidc-issuer", "", "", "OIDC issuer URL used for bearer-token authentication")
+	flags.StringVarP(clientID, "auth.oidc-client-id", "", "", "OIDC client ID")
+	flags.StringVarP(clientSecret, "auth.oidc-client-secret", "", "", "OIDC client secret")
+	flags.StringVarP(audience, "auth.oidc-audience", "", "", "Expected audience (aud claim) for validated tokens")
+	flags.DurationVarP(jwksRefreshInterval, "auth.jwks-refresh-interval", "", 15*time.Minute, "How often to refresh the OIDC provider's JSON Web Key Set")
+}
@@ -15,9 +15,15 @@
 package ctl
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"net/http"
 
 	"github.com/pilosa/pilosa"
+	"github.com/pilosa/pilosa/auth"
 	"github.com/spf13/pflag"
 )
 
@@ -28,14 +34,34 @@ type CommandWithTLSSupport interface {
 }
 
 // SetTLSConfig creates common TLS flags
-func SetTLSConfig(flags *pflag.FlagSet, certificatePath *string, certificateKeyPath *string, skipVerify *bool) {
+func SetTLSConfig(flags *pflag.FlagSet, certificatePath *string, certificateKeyPath *string, caCertificatePath *string, skipVerify *bool, clientAuth *string, enableClientCertAuth *bool) {
 	flags.StringVarP(certificatePath, "tls.certificate", "", "", "TLS certificate path (usually has the .crt or .pem extension")
 	flags.StringVarP(certificateKeyPath, "tls.key", "", "", "TLS certificate key path (usually has the .key extension")
+	flags.StringVarP(caCertificatePath, "tls.ca-certificate", "", "", "Path to a CA certificate bundle used to verify peer certificates")
 	flags.BoolVarP(skipVerify, "tls.skip-verify", "", false, "Skip TLS certificate verification (not secure)")
+	flags.StringVarP(clientAuth, "tls.client-auth", "", "none", "Client certificate auth mode for server listeners: none, request, or require")
+	flags.BoolVarP(enableClientCertAuth, "tls.enable-client-cert-auth", "", false, "Require and verify a client certificate (equivalent to --tls.client-auth=require)")
 }
 
-// CommandClient returns a pilosa.InternalHTTPClient for the command
+// CommandClient returns a pilosa.InternalHTTPClient for the command. If
+// cmd's host is a "discovery+<scheme>://" address, the returned client is
+// built from a one-time resolution of that address and never rotates as
+// the resolver's peer set changes afterward; long-running clients that
+// need to track a live-updating peer set should use CommandWatchingClient
+// instead.
 func CommandClient(cmd CommandWithTLSSupport) (*pilosa.InternalHTTPClient, error) {
+	httpClient, err := commandHTTPClient(cmd)
+	if err != nil {
+		return nil, err
+	}
+	return pilosa.NewInternalHTTPClient(cmd.TLSHost(), httpClient)
+}
+
+// commandHTTPClient builds the *http.Client shared by CommandClient and
+// CommandWatchingClient: TLS configuration (including the mTLS client
+// certificate and SNI routing hint) plus, when configured, an OIDC bearer
+// token transport.
+func commandHTTPClient(cmd CommandWithTLSSupport) (*http.Client, error) {
 	tlsConfig := cmd.TLSConfiguration()
 	var TLSConfig *tls.Config
 	if tlsConfig.CertificatePath != "" && tlsConfig.CertificateKeyPath != "" {
@@ -48,9 +74,173 @@ func CommandClient(cmd CommandWithTLSSupport) (*pilosa.InternalHTTPClient, error
 			InsecureSkipVerify: tlsConfig.SkipVerify,
 		}
 	}
-	client, err := pilosa.NewInternalHTTPClient(cmd.TLSHost(), pilosa.GetHTTPClient(TLSConfig))
+
+	if tlsConfig.CACertPath != "" {
+		pool, err := loadCertPool(tlsConfig.CACertPath)
+		if err != nil {
+			return nil, err
+		}
+		if TLSConfig == nil {
+			TLSConfig = &tls.Config{}
+		}
+		TLSConfig.RootCAs = pool
+	}
+
+	host := cmd.TLSHost()
+	if uri, err := pilosa.NewURIFromAddress(host); err == nil {
+		if hint, ok := uri.MultiplexScheme(); ok && hint == "sni" {
+			// The server multiplexes HTTP, TLS, and passthrough gRPC on a
+			// single port and routes TLS connections by SNI hostname, so
+			// the client must present the real hostname in its
+			// ClientHello even though it dials the shared port.
+			if TLSConfig == nil {
+				TLSConfig = &tls.Config{}
+			}
+			TLSConfig.ServerName = uri.Host()
+		}
+	}
+
+	httpClient := pilosa.GetHTTPClient(TLSConfig)
+	if authCmd, ok := cmd.(CommandWithAuthSupport); ok {
+		if authConfig := authCmd.AuthConfiguration(); authConfig.Issuer != "" {
+			source, err := auth.NewClientCredentialsSource(context.Background(), authConfig)
+			if err != nil {
+				return nil, err
+			}
+			httpClient.Transport = auth.NewTransport(httpClient.Transport, source)
+		}
+	}
+
+	return httpClient, nil
+}
+
+// ServerTLSConfig builds the *tls.Config a Pilosa node listener should use,
+// including mutual-TLS client certificate verification when configured. It
+// returns a nil config (and nil error) if no certificate is configured.
+func ServerTLSConfig(cmd CommandWithTLSSupport) (*tls.Config, error) {
+	tlsConfig := cmd.TLSConfiguration()
+	if tlsConfig.CertificatePath == "" || tlsConfig.CertificateKeyPath == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(tlsConfig.CertificatePath, tlsConfig.CertificateKeyPath)
 	if err != nil {
 		return nil, err
 	}
-	return client, err
+	config := &tls.Config{
+		Certificates: []tls.Certificate{cert},
+	}
+
+	if tlsConfig.CACertPath != "" {
+		pool, err := loadCertPool(tlsConfig.CACertPath)
+		if err != nil {
+			return nil, err
+		}
+		config.ClientCAs = pool
+	}
+
+	clientAuth, err := resolveClientAuth(tlsConfig)
+	if err != nil {
+		return nil, err
+	}
+	config.ClientAuth = clientAuth
+
+	if requiresClientCAs(clientAuth) && config.ClientCAs == nil {
+		return nil, fmt.Errorf("--tls.client-auth=require (or --tls.enable-client-cert-auth) also requires --tls.ca-certificate, to verify client certificates against; otherwise every client handshake fails")
+	}
+
+	return config, nil
+}
+
+// resolveClientAuth reconciles --tls.client-auth with the
+// --tls.enable-client-cert-auth shorthand. It is an error for both to be
+// set to conflicting values, rather than letting one silently override
+// the other.
+func resolveClientAuth(tlsConfig pilosa.TLSConfig) (tls.ClientAuthType, error) {
+	explicit := tlsConfig.ClientAuth != "" && tlsConfig.ClientAuth != "none"
+	if tlsConfig.EnableClientCertAuth {
+		if explicit && tlsConfig.ClientAuth != "require" {
+			return 0, fmt.Errorf("--tls.enable-client-cert-auth conflicts with --tls.client-auth=%s; use only one of them", tlsConfig.ClientAuth)
+		}
+		return tls.RequireAndVerifyClientCert, nil
+	}
+	return clientAuthType(tlsConfig.ClientAuth)
+}
+
+// clientAuthType maps the --tls.client-auth flag value to a
+// tls.ClientAuthType. It rejects any value outside {none, request, require}
+// rather than defaulting to no client-cert auth, so a typo on this
+// security-sensitive flag fails loudly instead of silently disabling it.
+func clientAuthType(mode string) (tls.ClientAuthType, error) {
+	switch mode {
+	case "", "none":
+		return tls.NoClientCert, nil
+	case "request":
+		return tls.RequestClientCert, nil
+	case "require":
+		return tls.RequireAndVerifyClientCert, nil
+	default:
+		return 0, fmt.Errorf("invalid --tls.client-auth %q: must be one of none, request, require", mode)
+	}
+}
+
+// requiresClientCAs reports whether auth verifies the client certificate
+// against a CA pool, and therefore needs one configured.
+func requiresClientCAs(auth tls.ClientAuthType) bool {
+	return auth == tls.RequireAndVerifyClientCert || auth == tls.VerifyClientCertIfGiven
+}
+
+// loadCertPool reads a PEM-encoded CA bundle from path into a fresh
+// x509.CertPool.
+func loadCertPool(path string) (*x509.CertPool, error) {
+	pemBytes, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, fmt.Errorf("no certificates found in %s", path)
+	}
+	return pool, nil
+}
+
+// peerPrincipalKey is the context key under which PeerCertMiddleware stores
+// the authenticated client certificate's identity.
+type peerPrincipalKey struct{}
+
+// PeerPrincipal describes the identity presented by a client certificate
+// during mutual TLS, for use in index/frame-level authorization decisions.
+type PeerPrincipal struct {
+	CommonName string
+	DNSNames   []string
+}
+
+// PeerPrincipalFromContext returns the PeerPrincipal attached to ctx by
+// PeerCertMiddleware, if any.
+func PeerPrincipalFromContext(ctx context.Context) (*PeerPrincipal, bool) {
+	p, ok := ctx.Value(peerPrincipalKey{}).(*PeerPrincipal)
+	return p, ok
+}
+
+// PeerCertMiddleware wraps next, attaching the verified client
+// certificate's CN and SANs to the request context so handlers can make
+// authorization decisions based on mTLS identity. It only attaches a
+// PeerPrincipal when the certificate chain was actually verified
+// (len(r.TLS.VerifiedChains) > 0): with ClientAuth modes that merely
+// request a certificate without verifying it (tls.RequestClientCert, which
+// ServerTLSConfig permits without a CA bundle), r.TLS.PeerCertificates is
+// populated from whatever the client presented, so trusting it here would
+// let a client claim any CommonName/DNSNames it likes.
+func PeerCertMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+			cert := r.TLS.PeerCertificates[0]
+			principal := &PeerPrincipal{
+				CommonName: cert.Subject.CommonName,
+				DNSNames:   cert.DNSNames,
+			}
+			r = r.WithContext(context.WithValue(r.Context(), peerPrincipalKey{}, principal))
+		}
+		next.ServeHTTP(w, r)
+	})
 }
@@ -0,0 +1,271 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/pilosa/pilosa"
+)
+
+type testTLSCommand struct {
+	config pilosa.TLSConfig
+}
+
+func (c testTLSCommand) TLSHost() string                    { return "localhost:10101" }
+func (c testTLSCommand) TLSConfiguration() pilosa.TLSConfig { return c.config }
+
+// writeTestCertAndCA generates a throwaway self-signed certificate and
+// writes it, its key, and a CA bundle containing it (it is its own CA) to
+// files under t.TempDir().
+func writeTestCertAndCA(t *testing.T) (certPath, keyPath, caPath string) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "pilosa-test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:         true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("creating certificate: %v", err)
+	}
+
+	dir := t.TempDir()
+	certPath = filepath.Join(dir, "cert.pem")
+	keyPath = filepath.Join(dir, "key.pem")
+	caPath = filepath.Join(dir, "ca.pem")
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+
+	if err := ioutil.WriteFile(certPath, certPEM, 0600); err != nil {
+		t.Fatalf("writing cert: %v", err)
+	}
+	if err := ioutil.WriteFile(keyPath, keyPEM, 0600); err != nil {
+		t.Fatalf("writing key: %v", err)
+	}
+	if err := ioutil.WriteFile(caPath, certPEM, 0600); err != nil {
+		t.Fatalf("writing ca bundle: %v", err)
+	}
+	return certPath, keyPath, caPath
+}
+
+func TestServerTLSConfig(t *testing.T) {
+	certPath, keyPath, caPath := writeTestCertAndCA(t)
+
+	tests := []struct {
+		name     string
+		config   pilosa.TLSConfig
+		wantNil  bool
+		wantErr  bool
+		wantAuth tls.ClientAuthType
+		wantCAs  bool
+	}{
+		{
+			name:    "no certificate configured",
+			config:  pilosa.TLSConfig{},
+			wantNil: true,
+		},
+		{
+			name: "no client-auth mode configured",
+			config: pilosa.TLSConfig{
+				CertificatePath:    certPath,
+				CertificateKeyPath: keyPath,
+			},
+			wantAuth: tls.NoClientCert,
+		},
+		{
+			name: "require without a CA bundle is an error",
+			config: pilosa.TLSConfig{
+				CertificatePath:    certPath,
+				CertificateKeyPath: keyPath,
+				ClientAuth:         "require",
+			},
+			wantErr: true,
+		},
+		{
+			name: "require with a CA bundle",
+			config: pilosa.TLSConfig{
+				CertificatePath:    certPath,
+				CertificateKeyPath: keyPath,
+				CACertPath:         caPath,
+				ClientAuth:         "require",
+			},
+			wantAuth: tls.RequireAndVerifyClientCert,
+			wantCAs:  true,
+		},
+		{
+			name: "request mode does not require a CA bundle",
+			config: pilosa.TLSConfig{
+				CertificatePath:    certPath,
+				CertificateKeyPath: keyPath,
+				ClientAuth:         "request",
+			},
+			wantAuth: tls.RequestClientCert,
+		},
+		{
+			name: "enable-client-cert-auth without a CA bundle is an error",
+			config: pilosa.TLSConfig{
+				CertificatePath:      certPath,
+				CertificateKeyPath:   keyPath,
+				EnableClientCertAuth: true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "enable-client-cert-auth with a CA bundle",
+			config: pilosa.TLSConfig{
+				CertificatePath:      certPath,
+				CertificateKeyPath:   keyPath,
+				CACertPath:           caPath,
+				EnableClientCertAuth: true,
+			},
+			wantAuth: tls.RequireAndVerifyClientCert,
+			wantCAs:  true,
+		},
+		{
+			name: "enable-client-cert-auth conflicts with an explicit weaker client-auth",
+			config: pilosa.TLSConfig{
+				CertificatePath:      certPath,
+				CertificateKeyPath:   keyPath,
+				CACertPath:           caPath,
+				ClientAuth:           "request",
+				EnableClientCertAuth: true,
+			},
+			wantErr: true,
+		},
+		{
+			name: "enable-client-cert-auth agrees with an explicit require",
+			config: pilosa.TLSConfig{
+				CertificatePath:      certPath,
+				CertificateKeyPath:   keyPath,
+				CACertPath:           caPath,
+				ClientAuth:           "require",
+				EnableClientCertAuth: true,
+			},
+			wantAuth: tls.RequireAndVerifyClientCert,
+			wantCAs:  true,
+		},
+		{
+			name: "unrecognized client-auth value is an error",
+			config: pilosa.TLSConfig{
+				CertificatePath:    certPath,
+				CertificateKeyPath: keyPath,
+				CACertPath:         caPath,
+				ClientAuth:         "requir",
+			},
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := testTLSCommand{config: tt.config}
+			got, err := ServerTLSConfig(cmd)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("expected an error, got none")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tt.wantNil {
+				if got != nil {
+					t.Fatalf("expected a nil config, got %+v", got)
+				}
+				return
+			}
+			if got.ClientAuth != tt.wantAuth {
+				t.Fatalf("ClientAuth = %v, want %v", got.ClientAuth, tt.wantAuth)
+			}
+			if (got.ClientCAs != nil) != tt.wantCAs {
+				t.Fatalf("ClientCAs set = %v, want %v", got.ClientCAs != nil, tt.wantCAs)
+			}
+		})
+	}
+}
+
+func TestPeerCertMiddleware(t *testing.T) {
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "attacker"}}
+
+	tests := []struct {
+		name          string
+		connState     *tls.ConnectionState
+		wantPrincipal bool
+	}{
+		{
+			name:          "no TLS connection state",
+			connState:     nil,
+			wantPrincipal: false,
+		},
+		{
+			name: "unverified peer certificate (e.g. --tls.client-auth=request) is not trusted",
+			connState: &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{cert},
+				VerifiedChains:   nil,
+			},
+			wantPrincipal: false,
+		},
+		{
+			name: "verified peer certificate is trusted",
+			connState: &tls.ConnectionState{
+				PeerCertificates: []*x509.Certificate{cert},
+				VerifiedChains:   [][]*x509.Certificate{{cert}},
+			},
+			wantPrincipal: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPrincipal *PeerPrincipal
+			handler := PeerCertMiddleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				gotPrincipal, _ = PeerPrincipalFromContext(r.Context())
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			req.TLS = tt.connState
+			handler.ServeHTTP(httptest.NewRecorder(), req)
+
+			if (gotPrincipal != nil) != tt.wantPrincipal {
+				t.Fatalf("principal attached = %v, want %v", gotPrincipal != nil, tt.wantPrincipal)
+			}
+		})
+	}
+}
@@ -0,0 +1,112 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ctl
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/pilosa/pilosa"
+)
+
+// discoveryClientRefreshInterval is how often a WatchingClient re-resolves
+// a "discovery+<scheme>://" target and rebuilds the InternalHTTPClient it
+// hands out, if the resolved peer set has changed.
+const discoveryClientRefreshInterval = 1 * time.Minute
+
+// WatchingClient wraps a pilosa.InternalHTTPClient built from a
+// "discovery+<scheme>://" address and keeps it pointed at a live-resolved
+// peer, swapping in a freshly built client whenever the underlying
+// pilosa.URIWatcher reports that the resolved set has changed.
+// pilosa.InternalHTTPClient has no notion of a resolver itself, so
+// rotation happens here instead: callers should fetch the client via
+// Client() for each outgoing request rather than caching the returned
+// pointer across requests.
+type WatchingClient struct {
+	mu      sync.RWMutex
+	client  *pilosa.InternalHTTPClient
+	watcher *pilosa.URIWatcher
+	done    chan struct{}
+}
+
+// Client returns the InternalHTTPClient currently pointed at the resolved
+// peer. It may return a different pointer on a later call, once the peer
+// set has been re-resolved.
+func (w *WatchingClient) Client() *pilosa.InternalHTTPClient {
+	w.mu.RLock()
+	defer w.mu.RUnlock()
+	return w.client
+}
+
+// Close stops watching for peer-set changes. It is a no-op if cmd's host
+// was not a discovery address, since then there is no watcher running.
+func (w *WatchingClient) Close() {
+	if w.watcher == nil {
+		return
+	}
+	w.watcher.Close()
+	<-w.done
+}
+
+// CommandWatchingClient is like CommandClient, but when cmd's host is a
+// "discovery+<scheme>://" address with a registered resolver, the
+// returned WatchingClient keeps rotating across that resolver's
+// live-updating peer set instead of resolving once at connect time and
+// holding onto a single, possibly now-dead, peer for the life of the
+// process. One-shot CLI commands should keep using CommandClient;
+// CommandWatchingClient is for clients that run long enough to outlive a
+// single resolved peer, such as the gossip/replication transport.
+func CommandWatchingClient(cmd CommandWithTLSSupport) (*WatchingClient, error) {
+	httpClient, err := commandHTTPClient(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	host := cmd.TLSHost()
+	client, err := pilosa.NewInternalHTTPClient(host, httpClient)
+	if err != nil {
+		return nil, err
+	}
+
+	resolver, logical, ok := pilosa.DiscoveryTarget(host)
+	if !ok {
+		return &WatchingClient{client: client, done: make(chan struct{})}, nil
+	}
+
+	w := &WatchingClient{
+		client:  client,
+		watcher: pilosa.WatchURIs(resolver, logical, discoveryClientRefreshInterval),
+		done:    make(chan struct{}),
+	}
+	go w.run(httpClient)
+	return w, nil
+}
+
+func (w *WatchingClient) run(httpClient *http.Client) {
+	defer close(w.done)
+	for uris := range w.watcher.Updates {
+		if len(uris) == 0 {
+			continue
+		}
+		client, err := pilosa.NewInternalHTTPClient(uris[0].HostPort(), httpClient)
+		if err != nil {
+			continue
+		}
+		w.mu.Lock()
+		w.client = client
+		w.mu.Unlock()
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package discovery provides built-in pilosa.URIResolver implementations.
+// Importing this package registers them with the pilosa package under the
+// "discovery+dns-srv" and "discovery+file" address schemes; see
+// pilosa.RegisterURIResolver and pilosa.NewURIFromAddress.
+package discovery
+
+import (
+	"context"
+	"net"
+	"strings"
+
+	"github.com/pilosa/pilosa"
+)
+
+func init() {
+	pilosa.RegisterURIResolver("dns-srv", DNSSRVResolver{})
+}
+
+// DNSSRVResolver resolves a logical name, such as
+// "_pilosa._tcp.example.com", to the set of URIs published as DNS SRV
+// records.
+type DNSSRVResolver struct {
+	// Scheme, if set, is applied to every resolved URI. Defaults to
+	// "http" via NewURIFromHostPort.
+	Scheme string
+}
+
+// Resolve implements pilosa.URIResolver.
+func (r DNSSRVResolver) Resolve(ctx context.Context, logical string) ([]pilosa.URI, error) {
+	_, records, err := net.DefaultResolver.LookupSRV(ctx, "", "", logical)
+	if err != nil {
+		return nil, err
+	}
+
+	uris := make([]pilosa.URI, 0, len(records))
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		uri, err := pilosa.NewURIFromHostPort(host, record.Port)
+		if err != nil {
+			return nil, err
+		}
+		if r.Scheme != "" {
+			if err := uri.SetScheme(r.Scheme); err != nil {
+				return nil, err
+			}
+		}
+		uris = append(uris, *uri)
+	}
+	return uris, nil
+}
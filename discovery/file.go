@@ -0,0 +1,57 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package discovery
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+
+	"github.com/pilosa/pilosa"
+)
+
+func init() {
+	pilosa.RegisterURIResolver("file", FileResolver{})
+}
+
+// FileResolver resolves a logical name by treating it as the path to a
+// JSON file containing an array of URI address strings, e.g.
+// ["10.0.0.1:10101", "10.0.0.2:10101"]. The file is re-read on every
+// Resolve call, so an operator can change the peer set simply by
+// rewriting it.
+type FileResolver struct{}
+
+// Resolve implements pilosa.URIResolver.
+func (FileResolver) Resolve(ctx context.Context, logical string) ([]pilosa.URI, error) {
+	b, err := ioutil.ReadFile(logical)
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []string
+	if err := json.Unmarshal(b, &addresses); err != nil {
+		return nil, err
+	}
+
+	uris := make([]pilosa.URI, len(addresses))
+	for i, address := range addresses {
+		uri, err := pilosa.NewURIFromAddress(address)
+		if err != nil {
+			return nil, err
+		}
+		uris[i] = *uri
+	}
+	return uris, nil
+}
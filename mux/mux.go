@@ -0,0 +1,233 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package mux implements a connection multiplexer that lets a single
+// listening port serve plaintext HTTP, TLS-terminated HTTPS, and
+// passthrough gRPC traffic, choosing a backend by peeking at the first
+// bytes of each accepted connection. TLS connections are further routed by
+// the ClientHello's SNI hostname, so a Pilosa cluster can front multiple
+// indexes or tenants behind one address.
+package mux
+
+import (
+	"bufio"
+	"crypto/tls"
+	"errors"
+	"net"
+	"strings"
+	"sync"
+)
+
+// peekBufferSize is the number of bytes buffered from the start of each
+// connection in order to make a routing decision. A TLS ClientHello is
+// expected to fit within this, per the request that inspired this package.
+const peekBufferSize = 1024
+
+// tlsRecordContentType is the first byte of a TLS handshake record.
+const tlsRecordContentType = 0x16
+
+// ErrClosed is returned by Accept once the Listener has been closed.
+var ErrClosed = errors.New("mux: listener closed")
+
+// Backend handles a connection that has been routed to it.
+type Backend interface {
+	Serve(conn net.Conn)
+}
+
+// BackendFunc adapts a plain function to a Backend.
+type BackendFunc func(conn net.Conn)
+
+// Serve implements Backend.
+func (f BackendFunc) Serve(conn net.Conn) { f(conn) }
+
+// Route associates an SNI hostname pattern with a Backend. Pattern supports
+// a single leading "*." wildcard, e.g. "*.tenant.example.com".
+type Route struct {
+	// Pattern is matched against the ClientHello's server_name extension.
+	Pattern string
+
+	// Backend receives the connection once it has been routed here.
+	Backend Backend
+
+	// Passthrough, when true, forwards the raw (still TLS-encrypted) bytes
+	// to Backend instead of terminating TLS locally first. This is how a
+	// frontend can forward gRPC-over-TLS to a backend without decrypting
+	// it.
+	Passthrough bool
+
+	// TLSConfig is used to terminate TLS locally when Passthrough is
+	// false. Ignored otherwise.
+	TLSConfig *tls.Config
+}
+
+// Listener wraps a net.Listener, demultiplexing plaintext HTTP connections
+// from TLS connections, and further routing TLS connections by SNI
+// hostname. It implements net.Listener so it can be used anywhere a normal
+// listener is expected.
+type Listener struct {
+	ln     net.Listener
+	http   Backend
+	routes []Route
+
+	conns chan net.Conn
+	errs  chan error
+
+	closeOnce sync.Once
+	done      chan struct{}
+}
+
+// New wraps ln. Connections that do not look like a TLS handshake are
+// handed to http; TLS connections are matched against routes in order, and
+// anything unmatched is returned from Accept for the caller to terminate
+// with a default certificate.
+func New(ln net.Listener, http Backend, routes ...Route) *Listener {
+	l := &Listener{
+		ln:     ln,
+		http:   http,
+		routes: routes,
+		conns:  make(chan net.Conn),
+		errs:   make(chan error, 1),
+		done:   make(chan struct{}),
+	}
+	go l.acceptLoop()
+	return l
+}
+
+// acceptLoop continuously accepts raw connections from the underlying
+// listener and dispatches each to its backend on its own goroutine, since
+// peeking at a slow client's first bytes must never block other
+// connections.
+func (l *Listener) acceptLoop() {
+	for {
+		conn, err := l.ln.Accept()
+		if err != nil {
+			select {
+			case l.errs <- err:
+			case <-l.done:
+			}
+			return
+		}
+		go l.route(conn)
+	}
+}
+
+// route peeks at conn's first bytes and either dispatches it to a
+// registered Backend or, if it should be handled by the caller of Accept
+// (plain HTTP with no dedicated backend, or an unmatched TLS route),
+// delivers it on l.conns.
+func (l *Listener) route(conn net.Conn) {
+	br := bufio.NewReaderSize(conn, peekBufferSize)
+	hdr, err := br.Peek(1)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	bc := &bufferedConn{Conn: conn, r: br}
+
+	if hdr[0] != tlsRecordContentType {
+		if l.http != nil {
+			l.http.Serve(bc)
+			return
+		}
+		l.deliver(bc)
+		return
+	}
+
+	serverName, ok := peekServerName(br)
+	if ok {
+		for _, route := range l.routes {
+			if matchHostname(route.Pattern, serverName) {
+				if route.Passthrough {
+					route.Backend.Serve(bc)
+					return
+				}
+				l.serveTLS(bc, route.TLSConfig, route.Backend)
+				return
+			}
+		}
+	}
+	// No route matched (or no SNI was presented); let the caller's Accept
+	// loop terminate TLS with its default configuration.
+	l.deliver(bc)
+}
+
+func (l *Listener) serveTLS(conn net.Conn, config *tls.Config, backend Backend) {
+	backend.Serve(tls.Server(conn, config))
+}
+
+func (l *Listener) deliver(conn net.Conn) {
+	select {
+	case l.conns <- conn:
+	case <-l.done:
+		conn.Close()
+	}
+}
+
+// Accept returns connections that were not claimed by a registered Backend:
+// plain HTTP traffic when no http Backend was configured, and TLS
+// connections whose SNI hostname matched no Route.
+func (l *Listener) Accept() (net.Conn, error) {
+	select {
+	case conn := <-l.conns:
+		return conn, nil
+	case err := <-l.errs:
+		return nil, err
+	case <-l.done:
+		return nil, ErrClosed
+	}
+}
+
+// Close closes the underlying listener.
+func (l *Listener) Close() error {
+	var err error
+	l.closeOnce.Do(func() {
+		err = l.ln.Close()
+		close(l.done)
+	})
+	return err
+}
+
+// Addr returns the underlying listener's network address.
+func (l *Listener) Addr() net.Addr {
+	return l.ln.Addr()
+}
+
+// bufferedConn is a net.Conn whose Read calls are served out of a
+// bufio.Reader that may already hold bytes peeked from the underlying
+// connection while a routing decision was made, replaying them before
+// reading any further bytes off the wire.
+type bufferedConn struct {
+	net.Conn
+	r *bufio.Reader
+}
+
+// Read implements net.Conn.
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.r.Read(b)
+}
+
+// matchHostname reports whether name satisfies pattern, which may have a
+// single leading "*." wildcard matching exactly one label.
+func matchHostname(pattern, name string) bool {
+	pattern = strings.ToLower(pattern)
+	name = strings.ToLower(name)
+	if !strings.HasPrefix(pattern, "*.") {
+		return pattern == name
+	}
+	i := strings.IndexByte(name, '.')
+	if i < 0 {
+		return false
+	}
+	return pattern[2:] == name[i+1:]
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import "bufio"
+
+// sniExtensionType is the TLS extension number for server_name (RFC 6066).
+const sniExtensionType = 0x0000
+
+// peekServerName reads (without consuming) the TLS record and handshake
+// headers buffered in br and extracts the server_name extension from a
+// ClientHello, if present. It only looks at the first record, which is
+// sufficient for any ClientHello that fits within peekBufferSize.
+//
+// It peeks incrementally: first just the 5-byte record header, then only
+// as many bytes as that header's length field declares (capped at
+// peekBufferSize). bufio.Reader.Peek(n) blocks on a live connection until
+// n bytes arrive, and a real ClientHello is followed by the client waiting
+// for a ServerHello rather than sending more data — so peeking
+// peekBufferSize bytes up front would block forever on any ClientHello
+// shorter than that.
+func peekServerName(br *bufio.Reader) (string, bool) {
+	header, err := br.Peek(5)
+	if err != nil {
+		return "", false
+	}
+
+	// TLS record header: type(1) + version(2) + length(2).
+	recordLen := int(header[3])<<8 | int(header[4])
+	total := 5 + recordLen
+	if total > peekBufferSize {
+		total = peekBufferSize
+	}
+
+	b, err := br.Peek(total)
+	if err != nil && len(b) == 0 {
+		return "", false
+	}
+	if len(b) < 5 {
+		return "", false
+	}
+	b = b[5:]
+	if len(b) > recordLen {
+		b = b[:recordLen]
+	}
+
+	// Handshake header: type(1) + length(3).
+	if len(b) < 4 || b[0] != 0x01 {
+		return "", false
+	}
+	b = b[4:]
+
+	// client_version(2) + random(32).
+	if len(b) < 34 {
+		return "", false
+	}
+	b = b[34:]
+
+	// session_id.
+	if len(b) < 1 {
+		return "", false
+	}
+	sessionIDLen := int(b[0])
+	b = b[1:]
+	if len(b) < sessionIDLen {
+		return "", false
+	}
+	b = b[sessionIDLen:]
+
+	// cipher_suites.
+	if len(b) < 2 {
+		return "", false
+	}
+	cipherSuitesLen := int(b[0])<<8 | int(b[1])
+	b = b[2:]
+	if len(b) < cipherSuitesLen {
+		return "", false
+	}
+	b = b[cipherSuitesLen:]
+
+	// compression_methods.
+	if len(b) < 1 {
+		return "", false
+	}
+	compressionMethodsLen := int(b[0])
+	b = b[1:]
+	if len(b) < compressionMethodsLen {
+		return "", false
+	}
+	b = b[compressionMethodsLen:]
+
+	// extensions (optional).
+	if len(b) < 2 {
+		return "", false
+	}
+	extensionsLen := int(b[0])<<8 | int(b[1])
+	b = b[2:]
+	if len(b) < extensionsLen {
+		return "", false
+	}
+	b = b[:extensionsLen]
+
+	for len(b) >= 4 {
+		extType := int(b[0])<<8 | int(b[1])
+		extLen := int(b[2])<<8 | int(b[3])
+		b = b[4:]
+		if len(b) < extLen {
+			return "", false
+		}
+		ext := b[:extLen]
+		b = b[extLen:]
+
+		if extType != sniExtensionType {
+			continue
+		}
+		return parseServerNameExtension(ext)
+	}
+	return "", false
+}
+
+// parseServerNameExtension parses the body of a server_name extension
+// (RFC 6066 section 3) and returns the first host_name entry.
+func parseServerNameExtension(b []byte) (string, bool) {
+	if len(b) < 2 {
+		return "", false
+	}
+	listLen := int(b[0])<<8 | int(b[1])
+	b = b[2:]
+	if len(b) < listLen {
+		return "", false
+	}
+	b = b[:listLen]
+
+	for len(b) >= 3 {
+		nameType := b[0]
+		nameLen := int(b[1])<<8 | int(b[2])
+		b = b[3:]
+		if len(b) < nameLen {
+			return "", false
+		}
+		name := b[:nameLen]
+		b = b[nameLen:]
+
+		const hostNameType = 0x00
+		if nameType == hostNameType {
+			return string(name), true
+		}
+	}
+	return "", false
+}
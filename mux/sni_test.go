@@ -0,0 +1,226 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package mux
+
+import (
+	"bufio"
+	"bytes"
+	"net"
+	"testing"
+	"time"
+)
+
+// sniExtensionBytes builds a server_name extension (RFC 6066 section 3)
+// carrying a single host_name entry.
+func sniExtensionBytes(name string) []byte {
+	nameBytes := []byte(name)
+
+	var serverNameEntry bytes.Buffer
+	serverNameEntry.WriteByte(0x00) // host_name
+	serverNameEntry.Write([]byte{byte(len(nameBytes) >> 8), byte(len(nameBytes))})
+	serverNameEntry.Write(nameBytes)
+
+	var serverNameList bytes.Buffer
+	listLen := serverNameEntry.Len()
+	serverNameList.Write([]byte{byte(listLen >> 8), byte(listLen)})
+	serverNameList.Write(serverNameEntry.Bytes())
+
+	var ext bytes.Buffer
+	ext.Write([]byte{0x00, 0x00}) // extension type: server_name
+	extLen := serverNameList.Len()
+	ext.Write([]byte{byte(extLen >> 8), byte(extLen)})
+	ext.Write(serverNameList.Bytes())
+	return ext.Bytes()
+}
+
+// clientHelloBytes builds a minimal but well-formed TLS 1.2 ClientHello
+// record wrapping the given extensions.
+func clientHelloBytes(extensions ...[]byte) []byte {
+	var body bytes.Buffer
+	body.Write([]byte{0x03, 0x03})             // client_version: TLS 1.2
+	body.Write(make([]byte, 32))               // random
+	body.WriteByte(0x00)                       // session_id length
+	body.Write([]byte{0x00, 0x02, 0xc0, 0x2f}) // cipher_suites: length 2, one suite
+	body.Write([]byte{0x01, 0x00})             // compression_methods: length 1, null
+
+	var extBuf bytes.Buffer
+	for _, ext := range extensions {
+		extBuf.Write(ext)
+	}
+	extLen := extBuf.Len()
+	body.Write([]byte{byte(extLen >> 8), byte(extLen)})
+	body.Write(extBuf.Bytes())
+
+	var handshake bytes.Buffer
+	handshake.WriteByte(0x01) // ClientHello
+	hLen := body.Len()
+	handshake.Write([]byte{byte(hLen >> 16), byte(hLen >> 8), byte(hLen)})
+	handshake.Write(body.Bytes())
+
+	var record bytes.Buffer
+	record.WriteByte(tlsRecordContentType)
+	record.Write([]byte{0x03, 0x01}) // record version
+	rLen := handshake.Len()
+	record.Write([]byte{byte(rLen >> 8), byte(rLen)})
+	record.Write(handshake.Bytes())
+
+	return record.Bytes()
+}
+
+func peekFrom(data []byte) (string, bool) {
+	br := bufio.NewReaderSize(bytes.NewReader(data), peekBufferSize)
+	return peekServerName(br)
+}
+
+func TestPeekServerName(t *testing.T) {
+	tests := []struct {
+		name     string
+		data     []byte
+		wantName string
+		wantOK   bool
+	}{
+		{
+			name:     "sni present",
+			data:     clientHelloBytes(sniExtensionBytes("tenant-a.example.com")),
+			wantName: "tenant-a.example.com",
+			wantOK:   true,
+		},
+		{
+			name:     "no extensions at all",
+			data:     clientHelloBytes(),
+			wantName: "",
+			wantOK:   false,
+		},
+		{
+			name:     "extensions present but no sni",
+			data:     clientHelloBytes([]byte{0x00, 0x23, 0x00, 0x00}), // session_ticket, empty
+			wantName: "",
+			wantOK:   false,
+		},
+		{
+			name:     "truncated record (cut mid-handshake)",
+			data:     clientHelloBytes(sniExtensionBytes("tenant-a.example.com"))[:10],
+			wantName: "",
+			wantOK:   false,
+		},
+		{
+			name:     "empty input",
+			data:     nil,
+			wantName: "",
+			wantOK:   false,
+		},
+		{
+			name:     "not a handshake record at all",
+			data:     []byte("GET / HTTP/1.1\r\n"),
+			wantName: "",
+			wantOK:   false,
+		},
+		{
+			name: "oversized client hello beyond the peek buffer",
+			data: func() []byte {
+				// A server_name long enough that the whole ClientHello
+				// exceeds peekBufferSize; the SNI extension itself falls
+				// outside what was buffered, so it must not be found (and
+				// must not panic).
+				huge := make([]byte, peekBufferSize*2)
+				for i := range huge {
+					huge[i] = 'a'
+				}
+				return clientHelloBytes(sniExtensionBytes(string(huge)))
+			}(),
+			wantName: "",
+			wantOK:   false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotName, gotOK := peekFrom(tt.data)
+			if gotOK != tt.wantOK || gotName != tt.wantName {
+				t.Fatalf("peekServerName() = (%q, %v), want (%q, %v)", gotName, gotOK, tt.wantName, tt.wantOK)
+			}
+		})
+	}
+}
+
+// TestPeekServerNameOverPipeDoesNotBlock guards against peekServerName
+// demanding more bytes than a real ClientHello record declares. Unlike a
+// bytes.Reader, net.Pipe only ever yields the bytes actually written to
+// it and then blocks, just like a real socket whose client has sent its
+// ClientHello and is now waiting for a ServerHello — so a buggy
+// peekServerName that over-reads would hang here instead of returning.
+func TestPeekServerNameOverPipeDoesNotBlock(t *testing.T) {
+	data := clientHelloBytes(sniExtensionBytes("tenant-a.example.com"))
+	if len(data) >= peekBufferSize {
+		t.Fatalf("test fixture ClientHello is too large: %d bytes", len(data))
+	}
+
+	clientConn, serverConn := net.Pipe()
+	defer serverConn.Close()
+
+	writeErrs := make(chan error, 1)
+	go func() {
+		_, err := clientConn.Write(data)
+		writeErrs <- err
+	}()
+	defer func() {
+		if err := <-writeErrs; err != nil {
+			t.Errorf("writing ClientHello: %v", err)
+		}
+		clientConn.Close()
+	}()
+
+	type result struct {
+		name string
+		ok   bool
+	}
+	resultCh := make(chan result, 1)
+	go func() {
+		br := bufio.NewReaderSize(serverConn, peekBufferSize)
+		name, ok := peekServerName(br)
+		resultCh <- result{name, ok}
+	}()
+
+	select {
+	case got := <-resultCh:
+		if !got.ok || got.name != "tenant-a.example.com" {
+			t.Fatalf("peekServerName() = (%q, %v), want (%q, true)", got.name, got.ok, "tenant-a.example.com")
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("peekServerName blocked waiting for more bytes than the ClientHello record declared")
+	}
+}
+
+func TestMatchHostname(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		want    bool
+	}{
+		{"tenant-a.example.com", "tenant-a.example.com", true},
+		{"tenant-a.example.com", "tenant-b.example.com", false},
+		{"*.example.com", "tenant-a.example.com", true},
+		{"*.example.com", "example.com", false},
+		{"*.example.com", "a.b.example.com", false},
+		{"TENANT-A.example.com", "tenant-a.EXAMPLE.com", true},
+	}
+
+	for _, tt := range tests {
+		got := matchHostname(tt.pattern, tt.name)
+		if got != tt.want {
+			t.Errorf("matchHostname(%q, %q) = %v, want %v", tt.pattern, tt.name, got, tt.want)
+		}
+	}
+}
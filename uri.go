@@ -15,12 +15,15 @@
 package pilosa
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/pilosa/pilosa/internal"
 )
@@ -42,6 +45,11 @@ var addressRegexp = regexp.MustCompile("^(([+a-z]+):\\/\\/)?([0-9a-z.-]+|\\[[:0-
 // 	localhost:10101
 // 	localhost
 // 	:10101
+//
+// The scheme may also carry a "+"-separated routing hint, such as
+// "https+sni", to signal that the connection should be routed through a
+// multiplexed port (see the mux package). The hint is stripped by
+// Normalize before the address is used to make an actual connection.
 type URI struct {
 	scheme string `json:"scheme"`
 	host   string `json:"host"`
@@ -78,8 +86,29 @@ func NewURIFromHostPort(host string, port uint16) (*URI, error) {
 	return uri, nil
 }
 
-// NewURIFromAddress parses the passed address and returns a URI.
+// discoveryResolveTimeout bounds how long a single discovery resolution
+// performed by NewURIFromAddress may take, so a hung DNS/file lookup
+// can't block flag parsing or client construction forever.
+const discoveryResolveTimeout = 10 * time.Second
+
+// NewURIFromAddress parses the passed address and returns a URI. Addresses
+// of the form "discovery+<scheme>://<logical>", such as
+// "discovery+dns-srv://_pilosa._tcp.example.com", are resolved to a
+// concrete host:port via the URIResolver registered for <scheme>; see
+// RegisterURIResolver and WatchURIs for periodic re-resolution. It is an
+// error to use a "discovery+<scheme>://" address for which no resolver has
+// been registered, rather than silently parsing "discovery+<scheme>" as a
+// literal (and almost certainly wrong) URI scheme.
 func NewURIFromAddress(address string) (*URI, error) {
+	if scheme, _, ok := splitDiscoveryAddress(address); ok {
+		resolver, registered := lookupURIResolver(scheme)
+		if !registered {
+			return nil, fmt.Errorf("no URIResolver registered for discovery scheme %q", scheme)
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), discoveryResolveTimeout)
+		defer cancel()
+		return resolveAddressWith(ctx, resolver, address)
+	}
 	return parseAddress(address)
 }
 
@@ -133,14 +162,31 @@ func (u *URI) HostPort() string {
 	return s
 }
 
+// stripSchemeHint removes any "+"-separated routing hint from scheme,
+// e.g. "https+sni" becomes "https".
+func stripSchemeHint(scheme string) string {
+	if index := strings.Index(scheme, "+"); index >= 0 {
+		return scheme[:index]
+	}
+	return scheme
+}
+
 // Normalize returns the address in a form usable by a HTTP client.
 func (u *URI) Normalize() string {
-	scheme := u.scheme
-	index := strings.Index(scheme, "+")
-	if index >= 0 {
-		scheme = scheme[:index]
+	return fmt.Sprintf("%s://%s:%d", stripSchemeHint(u.scheme), u.host, u.port)
+}
+
+// MultiplexScheme returns the routing hint suffix of the URI's scheme, if
+// any, and whether one was present. For example, the scheme "https+sni"
+// returns ("sni", true). This lets operators encode routing intent, such
+// as "route through the SNI-multiplexed port", directly in a URI without
+// inventing a separate flag.
+func (u *URI) MultiplexScheme() (string, bool) {
+	index := strings.Index(u.scheme, "+")
+	if index < 0 {
+		return "", false
 	}
-	return fmt.Sprintf("%s://%s:%d", scheme, u.host, u.port)
+	return u.scheme[index+1:], true
 }
 
 // String returns the address as a string.
@@ -178,6 +224,98 @@ func (u URI) Type() string {
 	return "URI"
 }
 
+// The following methods are required to implement the pflag Value
+// interface for a comma-separated list of URIs, e.g. for --cluster.hosts.
+
+// Set parses a comma-separated list of addresses such as
+// "http://n1:10101,https://n2:10101,n3" into *u, validating each entry and
+// dropping duplicates. Like the single-URI Set, an entry of the form
+// "discovery+<scheme>://<logical>" is resolved through the registered
+// URIResolver as part of parsing, so Set can block on network or file I/O;
+// callers that need a live-updating peer set rather than a one-time
+// resolution at flag-parse time should use WatchURIs instead.
+func (u *URIs) Set(value string) error {
+	var uris URIs
+	seen := make(map[URI]bool)
+	for _, s := range strings.Split(value, ",") {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		uri, err := NewURIFromAddress(s)
+		if err != nil {
+			return fmt.Errorf("parsing uri %q: %v", s, err)
+		}
+		if seen[*uri] {
+			continue
+		}
+		seen[*uri] = true
+		uris = append(uris, *uri)
+	}
+	*u = uris
+	return nil
+}
+
+// Type returns the type of a URIs value.
+func (u URIs) Type() string {
+	return "URIs"
+}
+
+// String returns the comma-separated string representation of u.
+func (u URIs) String() string {
+	return strings.Join(u.StringSlice(), ",")
+}
+
+// StringSlice returns each URI in u rendered as a dialable
+// "scheme://host:port" string, with any "+"-separated routing hint (e.g.
+// the "sni" in "https+sni") stripped, since that hint is metadata for the
+// resolver/dialer that produced the URI and not part of the address
+// itself.
+func (u URIs) StringSlice() []string {
+	s := make([]string, len(u))
+	for i, uri := range u {
+		s[i] = uri.Normalize()
+	}
+	return s
+}
+
+// URLSlice returns each URI in u converted to a url.URL, with any
+// "+"-separated routing hint stripped from the scheme so the result is a
+// usable URL (url.URL{Scheme: "https+sni"} is not).
+func (u URIs) URLSlice() []url.URL {
+	s := make([]url.URL, len(u))
+	for i, uri := range u {
+		s[i] = url.URL{
+			Scheme: stripSchemeHint(uri.scheme),
+			Host:   uri.HostPort(),
+		}
+	}
+	return s
+}
+
+// MarshalJSON marshals URIs into a JSON array of URI strings.
+func (u URIs) MarshalJSON() ([]byte, error) {
+	return json.Marshal(u.StringSlice())
+}
+
+// UnmarshalJSON unmarshals a JSON array of URI strings into u.
+func (u *URIs) UnmarshalJSON(b []byte) error {
+	var addresses []string
+	if err := json.Unmarshal(b, &addresses); err != nil {
+		return err
+	}
+	uris := make(URIs, len(addresses))
+	for i, address := range addresses {
+		uri, err := NewURIFromAddress(address)
+		if err != nil {
+			return fmt.Errorf("parsing uri %q: %v", address, err)
+		}
+		uris[i] = *uri
+	}
+	*u = uris
+	return nil
+}
+
 func parseAddress(address string) (uri *URI, err error) {
 	m := addressRegexp.FindStringSubmatch(address)
 	if m == nil {
@@ -0,0 +1,211 @@
+// Copyright 2017 Pilosa Corp.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package pilosa
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// discoverySchemePrefix is the address prefix that requests dynamic
+// resolution, e.g. "discovery+dns-srv://_pilosa._tcp.example.com".
+const discoverySchemePrefix = "discovery+"
+
+// URIResolver resolves a logical service name into the set of concrete
+// URIs that currently back it. Implementations back the
+// "discovery+<scheme>://<logical>" address family accepted by
+// NewURIFromAddress, decoupling cluster membership from static seed lists.
+type URIResolver interface {
+	// Resolve returns the current URIs for logical.
+	Resolve(ctx context.Context, logical string) ([]URI, error)
+}
+
+var (
+	uriResolversMu sync.RWMutex
+	uriResolvers   = map[string]URIResolver{}
+)
+
+// RegisterURIResolver makes resolver available for addresses of the form
+// "discovery+<scheme>://<logical>". It is typically called from the
+// init function of a resolver implementation, such as the built-in
+// DNS SRV and static file resolvers in the discovery subpackage.
+func RegisterURIResolver(scheme string, resolver URIResolver) {
+	uriResolversMu.Lock()
+	defer uriResolversMu.Unlock()
+	uriResolvers[scheme] = resolver
+}
+
+func lookupURIResolver(scheme string) (URIResolver, bool) {
+	uriResolversMu.RLock()
+	defer uriResolversMu.RUnlock()
+	r, ok := uriResolvers[scheme]
+	return r, ok
+}
+
+// splitDiscoveryAddress splits a "discovery+<scheme>://<logical>" address
+// into its scheme and logical name. ok is false for any address that isn't
+// in this form.
+func splitDiscoveryAddress(address string) (scheme, logical string, ok bool) {
+	if !strings.HasPrefix(address, discoverySchemePrefix) {
+		return "", "", false
+	}
+	rest := strings.TrimPrefix(address, discoverySchemePrefix)
+	idx := strings.Index(rest, "://")
+	if idx < 0 {
+		return "", "", false
+	}
+	return rest[:idx], rest[idx+3:], true
+}
+
+// DiscoveryTarget reports whether address is a "discovery+<scheme>://"
+// address for which a URIResolver is registered, returning that resolver
+// and the logical name to pass to its Resolve method. It lets a caller
+// that already has a one-shot URI from NewURIFromAddress start a
+// URIWatcher for the same address, e.g. to keep an HTTP client pointed at
+// a live-updating peer set rather than the single peer resolved at
+// connect time.
+func DiscoveryTarget(address string) (resolver URIResolver, logical string, ok bool) {
+	scheme, logical, ok := splitDiscoveryAddress(address)
+	if !ok {
+		return nil, "", false
+	}
+	resolver, registered := lookupURIResolver(scheme)
+	if !registered {
+		return nil, "", false
+	}
+	return resolver, logical, true
+}
+
+// resolveAddressWith resolves a discovery address to its first concrete
+// URI using resolver, which the caller has already looked up for the
+// address's scheme.
+func resolveAddressWith(ctx context.Context, resolver URIResolver, address string) (*URI, error) {
+	scheme, logical, ok := splitDiscoveryAddress(address)
+	if !ok {
+		return nil, fmt.Errorf("not a discovery address: %s", address)
+	}
+	uris, err := resolver.Resolve(ctx, logical)
+	if err != nil {
+		return nil, err
+	}
+	if len(uris) == 0 {
+		return nil, fmt.Errorf("resolver for %q returned no URIs for %q", scheme, logical)
+	}
+	return &uris[0], nil
+}
+
+// URIWatcher periodically re-resolves a logical address via a URIResolver
+// and publishes the resolved URI set on Updates whenever it changes,
+// letting a client rotate across a live-updating peer set instead of a
+// static seed list.
+type URIWatcher struct {
+	// Updates receives the current URI set every time it changes. It is
+	// buffered with capacity 1; a slow consumer sees only the latest set.
+	// It is closed once the watcher stops.
+	Updates chan []URI
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// WatchURIs starts periodically resolving logical via resolver, publishing
+// the resolved set on the returned URIWatcher's Updates channel whenever it
+// changes. Call Close to stop watching.
+func WatchURIs(resolver URIResolver, logical string, interval time.Duration) *URIWatcher {
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &URIWatcher{
+		Updates: make(chan []URI, 1),
+		cancel:  cancel,
+		done:    make(chan struct{}),
+	}
+	go w.run(ctx, resolver, logical, interval)
+	return w
+}
+
+func (w *URIWatcher) run(ctx context.Context, resolver URIResolver, logical string, interval time.Duration) {
+	defer close(w.done)
+	defer close(w.Updates)
+
+	var last []URI
+	resolve := func() {
+		uris, err := resolver.Resolve(ctx, logical)
+		if err != nil || uriSetEqual(last, uris) {
+			return
+		}
+		last = uris
+		w.publish(uris)
+	}
+
+	resolve()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			resolve()
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// publish delivers uris on w.Updates without blocking: if the channel's
+// single buffer slot is already occupied by a value no one has consumed
+// yet, that stale value is dropped in favor of uris, so a slow consumer
+// always sees only the latest resolved set rather than stalling the
+// watcher's resolve loop.
+func (w *URIWatcher) publish(uris []URI) {
+	for {
+		select {
+		case w.Updates <- uris:
+			return
+		default:
+		}
+		select {
+		case <-w.Updates:
+		default:
+		}
+	}
+}
+
+// Close stops the watcher and waits for its goroutine to exit.
+func (w *URIWatcher) Close() {
+	w.cancel()
+	<-w.done
+}
+
+// uriSetEqual reports whether a and b contain the same URIs, ignoring
+// order, since resolvers such as DNS SRV return records in
+// priority/weight-shuffled order even when the underlying set of peers
+// hasn't changed.
+func uriSetEqual(a, b []URI) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	counts := make(map[URI]int, len(a))
+	for _, uri := range a {
+		counts[uri]++
+	}
+	for _, uri := range b {
+		counts[uri]--
+		if counts[uri] < 0 {
+			return false
+		}
+	}
+	return true
+}